@@ -0,0 +1,219 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+
+	"sigs.k8s.io/cluster-api/cloud/google/machinesetup"
+)
+
+// masterRole and nodeRole are the two values GenerateJoinMetadata accepts,
+// mirroring util.IsMaster without requiring callers to re-derive it.
+const (
+	masterRole = "master"
+	nodeRole   = "node"
+)
+
+// SSHRunner executes cmd against a specific machine over SSH and returns its
+// combined output. Implementations close over the cluster/machine that
+// GCEClient.remoteSshCommand needs, so BootstrapProvider methods don't have
+// to take them directly.
+type SSHRunner func(cmd string) (string, error)
+
+// BootstrapProvider hides the assumption, baked into the rest of this file
+// until now, that every machine is bootstrapped with kubeadm on Ubuntu. The
+// OS recorded in the machine-setup configmap (machinesetup.ConfigParams.OS)
+// selects an implementation.
+type BootstrapProvider interface {
+	// GenerateJoinMetadata builds the instance-metadata key/value pairs
+	// (startup script, join tokens, etc.) needed for machine to join
+	// cluster with the given role (masterRole or nodeRole).
+	GenerateJoinMetadata(cluster *clusterv1.Cluster, machine *clusterv1.Machine, role string) (map[string]string, error)
+	// UpgradeControlPlane upgrades kubeadm and the static control-plane
+	// pods from oldVersion to newVersion. Only called for master machines.
+	UpgradeControlPlane(ctx context.Context, ssh SSHRunner, oldVersion string, newVersion string) error
+	// UpgradeKubelet upgrades the kubelet package to newVersion.
+	UpgradeKubelet(ctx context.Context, ssh SSHRunner, newVersion string) error
+}
+
+// bootstrapProvider selects a BootstrapProvider for the given machine-setup
+// OS string. Ubuntu, and anything unrecognized, keeps the existing
+// kubeadm-on-Ubuntu behavior; RPM-based distros get cloud-init; Flatcar and
+// other CoreOS derivatives get Ignition. kubeletConfigYAML, if non-empty, is
+// the merged KubeletConfiguration GenerateJoinMetadata wires into the
+// startup script it produces; pass "" where no KubeletConfig applies, e.g.
+// an in-place kubelet upgrade that isn't also reprovisioning the node.
+func (gce *GCEClient) bootstrapProvider(os string, project string, machineSetupMetadata machinesetup.Metadata, kubeletConfigYAML string) BootstrapProvider {
+	base := kubeadmBase{
+		kubeadmToken:         gce.kubeadmToken,
+		project:              project,
+		machineSetupMetadata: machineSetupMetadata,
+		kubeletConfigYAML:    kubeletConfigYAML,
+	}
+	switch {
+	case strings.Contains(os, "flatcar"), strings.Contains(os, "coreos"):
+		return &ignitionBootstrapProvider{kubeadmBase: base}
+	case strings.Contains(os, "centos"), strings.Contains(os, "rhel"):
+		return &cloudInitBootstrapProvider{kubeadmBase: base}
+	default:
+		return &kubeadmBootstrapProvider{kubeadmBase: base}
+	}
+}
+
+// kubeadmBase holds the context every BootstrapProvider implementation
+// needs to assemble join metadata, regardless of which startup-script
+// format it ultimately produces.
+type kubeadmBase struct {
+	kubeadmToken         string
+	project              string
+	machineSetupMetadata machinesetup.Metadata
+	kubeletConfigYAML    string
+}
+
+// kubeadmBootstrapProvider is the original kubeadm-on-Ubuntu behavior,
+// unchanged in observable output from before this file existed.
+type kubeadmBootstrapProvider struct {
+	kubeadmBase
+}
+
+func (p *kubeadmBootstrapProvider) GenerateJoinMetadata(cluster *clusterv1.Cluster, machine *clusterv1.Machine, role string) (map[string]string, error) {
+	var metadata map[string]string
+	var err error
+	if role == masterRole {
+		metadata, err = masterMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	} else {
+		metadata, err = nodeMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.kubeletConfigYAML != "" {
+		metadata["startup-script"] += "\n" + kubeletConfigStartupScript(p.kubeletConfigYAML)
+	}
+	return metadata, nil
+}
+
+func (p *kubeadmBootstrapProvider) UpgradeControlPlane(ctx context.Context, ssh SSHRunner, oldVersion string, newVersion string) error {
+	if oldVersion == newVersion {
+		return nil
+	}
+	cmd := fmt.Sprintf(
+		"curl -sSL https://dl.k8s.io/release/v%s/bin/linux/amd64/kubeadm | sudo tee /usr/bin/kubeadm > /dev/null; "+
+			"sudo chmod a+rx /usr/bin/kubeadm", newVersion)
+	if _, err := ssh(cmd); err != nil {
+		return err
+	}
+	_, err := ssh(fmt.Sprintf("sudo kubeadm upgrade apply v%s -y", newVersion))
+	return err
+}
+
+func (p *kubeadmBootstrapProvider) UpgradeKubelet(ctx context.Context, ssh SSHRunner, newVersion string) error {
+	_, err := ssh(fmt.Sprintf("sudo apt-get install kubelet=%s-00", newVersion))
+	return err
+}
+
+// cloudInitBootstrapProvider targets RPM-based distros (CentOS, RHEL) where
+// "apt-get install kubelet=X-00" is meaningless. It assembles a cloud-init
+// YAML document instead of the shell-snippet metadata keys the kubeadm
+// provider writes, and upgrades packages with yum.
+type cloudInitBootstrapProvider struct {
+	kubeadmBase
+}
+
+func (p *cloudInitBootstrapProvider) GenerateJoinMetadata(cluster *clusterv1.Cluster, machine *clusterv1.Machine, role string) (map[string]string, error) {
+	var inner map[string]string
+	var err error
+	if role == masterRole {
+		inner, err = masterMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	} else {
+		inner, err = nodeMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.kubeletConfigYAML != "" {
+		inner["startup-script"] += "\n" + kubeletConfigStartupScript(p.kubeletConfigYAML)
+	}
+
+	var cloudInit strings.Builder
+	cloudInit.WriteString("#cloud-config\n")
+	cloudInit.WriteString("package_update: true\n")
+	cloudInit.WriteString("runcmd:\n")
+	if startup, ok := inner["startup-script"]; ok {
+		for _, line := range strings.Split(strings.TrimSpace(startup), "\n") {
+			fmt.Fprintf(&cloudInit, "  - %s\n", line)
+		}
+	}
+
+	return map[string]string{"user-data": cloudInit.String()}, nil
+}
+
+func (p *cloudInitBootstrapProvider) UpgradeControlPlane(ctx context.Context, ssh SSHRunner, oldVersion string, newVersion string) error {
+	if oldVersion == newVersion {
+		return nil
+	}
+	if _, err := ssh(fmt.Sprintf("sudo yum install -y kubeadm-%s", newVersion)); err != nil {
+		return err
+	}
+	_, err := ssh(fmt.Sprintf("sudo kubeadm upgrade apply v%s -y", newVersion))
+	return err
+}
+
+func (p *cloudInitBootstrapProvider) UpgradeKubelet(ctx context.Context, ssh SSHRunner, newVersion string) error {
+	_, err := ssh(fmt.Sprintf("sudo yum install -y kubelet-%s", newVersion))
+	return err
+}
+
+// ignitionBootstrapProvider targets Flatcar/CoreOS images, which have no
+// package manager and are expected to be replaced rather than patched. It
+// still produces metadata (an Ignition config under the "user-data" key so
+// the GCE Ignition provider picks it up), but refuses in-place upgrades so
+// callers fall back to UpdateStrategy RecreateStrategy instead of silently
+// no-op'ing.
+type ignitionBootstrapProvider struct {
+	kubeadmBase
+}
+
+func (p *ignitionBootstrapProvider) GenerateJoinMetadata(cluster *clusterv1.Cluster, machine *clusterv1.Machine, role string) (map[string]string, error) {
+	var inner map[string]string
+	var err error
+	if role == masterRole {
+		inner, err = masterMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	} else {
+		inner, err = nodeMetadata(p.kubeadmToken, cluster, machine, p.project, &p.machineSetupMetadata)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.kubeletConfigYAML != "" {
+		inner["startup-script"] += "\n" + kubeletConfigStartupScript(p.kubeletConfigYAML)
+	}
+
+	ignition := fmt.Sprintf(`{"ignition":{"version":"2.3.0"},"systemd":{"units":[{"name":"kubeadm-join.service","enabled":true,"contents":%q}]}}`,
+		inner["startup-script"])
+	return map[string]string{"user-data": ignition}, nil
+}
+
+func (p *ignitionBootstrapProvider) UpgradeControlPlane(ctx context.Context, ssh SSHRunner, oldVersion string, newVersion string) error {
+	return fmt.Errorf("in-place control-plane upgrades are not supported on Ignition-based images; use UpdateStrategy %q instead", RecreateStrategy)
+}
+
+func (p *ignitionBootstrapProvider) UpgradeKubelet(ctx context.Context, ssh SSHRunner, newVersion string) error {
+	return fmt.Errorf("in-place kubelet upgrades are not supported on Ignition-based images; use UpdateStrategy %q instead", RecreateStrategy)
+}
@@ -0,0 +1,201 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ResolvedImageAnnotationKey records, on the Machine, the exact image URL
+// the node actually booted -- as opposed to the (possibly a family or a
+// fallback chain) reference the user configured -- so it can be audited.
+const ResolvedImageAnnotationKey = "gcp-resolved-image"
+
+// imageRefPattern matches a fully-qualified "projects/<p>/global/images/..."
+// reference, with an optional "family/" segment selecting the family form.
+var imageRefPattern = regexp.MustCompile(`^projects/(.+)/global/images/(family/)?(.+)$`)
+
+// ImageResolver turns a user-supplied image reference into the concrete
+// image URL to boot from. Each implementation covers one of the ways a
+// machine-setup configmap can name an image.
+type ImageResolver interface {
+	Resolve(project string) (string, error)
+}
+
+// explicitImageResolver resolves a fully-qualified
+// "projects/.../global/images/<name>" URL by confirming it still exists.
+type explicitImageResolver struct {
+	gce     *GCEClient
+	project string
+	name    string
+}
+
+func (r *explicitImageResolver) Resolve(project string) (string, error) {
+	if _, err := r.gce.computeService.ImagesGet(r.project, r.name); err != nil {
+		return "", fmt.Errorf("image %s/%s not found: %v", r.project, r.name, err)
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", r.project, r.name), nil
+}
+
+// familyImageResolver resolves a "family:<family>" reference to the latest
+// non-deprecated image in that family.
+type familyImageResolver struct {
+	gce     *GCEClient
+	project string
+	family  string
+}
+
+func (r *familyImageResolver) Resolve(project string) (string, error) {
+	image, err := r.gce.computeService.ImagesGetFromFamily(r.project, r.family)
+	if err != nil {
+		return "", fmt.Errorf("no non-deprecated image in family %s/%s: %v", r.project, r.family, err)
+	}
+	return image.SelfLink, nil
+}
+
+// digestPinnedImageResolver locks an image reference to a specific
+// image identity, refusing to resolve if the live image's identity has
+// drifted -- this is what lets an operator pin a node to exactly the bits
+// they reviewed.
+type digestPinnedImageResolver struct {
+	gce     *GCEClient
+	project string
+	name    string
+	id      string
+}
+
+func (r *digestPinnedImageResolver) Resolve(project string) (string, error) {
+	image, err := r.gce.computeService.ImagesGet(r.project, r.name)
+	if err != nil {
+		return "", fmt.Errorf("image %s/%s not found: %v", r.project, r.name, err)
+	}
+	// image.ImageEncryptionKey.Sha256, if used here, would be the SHA256 of
+	// a customer-supplied CMEK key -- nil for the common case of an
+	// unencrypted image, so it would never catch drift for the images most
+	// operators actually pin. image.Id is the field GCE actually guarantees
+	// is unique and immutable to this exact image resource: it changes if
+	// the image is ever deleted and recreated under the same name, which
+	// is exactly the drift a pin is meant to detect.
+	actual := strconv.FormatUint(image.Id, 10)
+	if actual != r.id {
+		return "", fmt.Errorf("image %s/%s id %s does not match pinned %s", r.project, r.name, actual, r.id)
+	}
+	return fmt.Sprintf("projects/%s/global/images/%s", r.project, r.name), nil
+}
+
+// fallbackChainResolver tries each resolver in order and returns the first
+// one that resolves successfully.
+type fallbackChainResolver struct {
+	resolvers []ImageResolver
+}
+
+func (r *fallbackChainResolver) Resolve(project string) (string, error) {
+	var errs []string
+	for _, resolver := range r.resolvers {
+		image, err := resolver.Resolve(project)
+		if err == nil {
+			return image, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", fmt.Errorf("no image in fallback chain resolved: %s", strings.Join(errs, "; "))
+}
+
+// imageResolverFor parses img (the value returned by
+// machineSetupConfigs.GetImage) into an ImageResolver. A bare name with no
+// "projects/" prefix, or a "family:" reference, is resolved against
+// project so single-word machine-setup configs keep working; a
+// fully-qualified "projects/.../global/images/..." URL (with an optional
+// "family/" segment) is honored as given.
+func (gce *GCEClient) imageResolverFor(project string, img string) ImageResolver {
+	// A comma-separated reference is an ordered fallback chain, e.g.
+	// "family:gke-node,family:ubuntu-2204-lts,projects/.../defaultImg".
+	if strings.Contains(img, ",") {
+		var resolvers []ImageResolver
+		for _, ref := range strings.Split(img, ",") {
+			resolvers = append(resolvers, gce.imageResolverFor(project, strings.TrimSpace(ref)))
+		}
+		return &fallbackChainResolver{resolvers: resolvers}
+	}
+	if id, name, ok := parseDigestPin(img); ok {
+		return &digestPinnedImageResolver{gce: gce, project: project, name: name, id: id}
+	}
+	if matches := imageRefPattern.FindStringSubmatch(img); matches != nil {
+		refProject, family, name := matches[1], matches[2], matches[3]
+		if family != "" {
+			return &familyImageResolver{gce: gce, project: refProject, family: name}
+		}
+		return &explicitImageResolver{gce: gce, project: refProject, name: name}
+	}
+	if family := strings.TrimPrefix(img, "family:"); family != img {
+		return &familyImageResolver{gce: gce, project: project, family: family}
+	}
+	return &explicitImageResolver{gce: gce, project: project, name: img}
+}
+
+// parseDigestPin recognizes the "name@sha256:<id>" pin syntax. The part
+// after "@sha256:" is matched against the pinned image's immutable
+// resource Id (see digestPinnedImageResolver) rather than a real SHA256
+// digest -- GCE does not publish a content hash for an existing image --
+// but the "@sha256:" spelling is kept as-is since it's the syntax
+// operators already configure.
+func parseDigestPin(img string) (id string, name string, ok bool) {
+	parts := strings.SplitN(img, "@sha256:", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[1], parts[0], true
+}
+
+// imageCache memoizes resolved image URLs for the lifetime of a single
+// GCEClient so a reconcile that touches many machines sharing the same
+// image reference doesn't repeat ImagesGet/ImagesGetFromFamily calls.
+type imageCache struct {
+	mu    sync.Mutex
+	cache map[string]string
+}
+
+// resolveImage runs the ImageResolver parsed from img and caches the
+// result per img+project so a reconcile that touches many machines
+// sharing the same reference doesn't repeat the lookup. Unlike the
+// previous getImagePath, a reference that resolves to nothing is returned
+// as a structured error instead of silently substituting a default image.
+func (gce *GCEClient) resolveImage(project string, img string) (string, error) {
+	key := project + "|" + img
+
+	gce.imageResolverCache.mu.Lock()
+	if gce.imageResolverCache.cache == nil {
+		gce.imageResolverCache.cache = make(map[string]string)
+	}
+	if cached, ok := gce.imageResolverCache.cache[key]; ok {
+		gce.imageResolverCache.mu.Unlock()
+		return cached, nil
+	}
+	gce.imageResolverCache.mu.Unlock()
+
+	resolved, err := gce.imageResolverFor(project, img).Resolve(project)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve image %q: %v", img, err)
+	}
+
+	gce.imageResolverCache.mu.Lock()
+	gce.imageResolverCache.cache[key] = resolved
+	gce.imageResolverCache.mu.Unlock()
+
+	return resolved, nil
+}
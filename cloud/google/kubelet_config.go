@@ -0,0 +1,136 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	gceconfigv1 "sigs.k8s.io/cluster-api/cloud/google/gceproviderconfig/v1alpha1"
+)
+
+// kubeletConfigMetadataKey is the instance-metadata key the startup script
+// reads to populate /var/lib/kubelet/config.yaml before kubelet starts.
+const kubeletConfigMetadataKey = "kubelet-config"
+
+// kubeletConfigDropInPath is the systemd drop-in BootstrapProvider
+// implementations add to the kubelet unit so it passes --config at the
+// path the startup script writes the merged KubeletConfiguration to,
+// overriding whatever kubeadm generated on its own during join/init.
+const kubeletConfigDropInPath = "/etc/systemd/system/kubelet.service.d/20-gce-kubelet-config.conf"
+
+// kubeletConfigStartupScript renders the shell commands a BootstrapProvider
+// appends to its startup script when configYAML is non-empty: drop the
+// merged KubeletConfiguration at /var/lib/kubelet/config.yaml, point
+// kubelet's systemd unit at it via --config, and restart kubelet so the
+// change actually takes effect instead of only ever sitting in instance
+// metadata unread.
+func kubeletConfigStartupScript(configYAML string) string {
+	return fmt.Sprintf(`mkdir -p /var/lib/kubelet
+cat > /var/lib/kubelet/config.yaml <<'KUBELET_CONFIG_EOF'
+%s
+KUBELET_CONFIG_EOF
+mkdir -p $(dirname %s)
+cat > %s <<'KUBELET_DROPIN_EOF'
+[Service]
+Environment="KUBELET_EXTRA_ARGS=--config=/var/lib/kubelet/config.yaml"
+KUBELET_DROPIN_EOF
+systemctl daemon-reload
+systemctl restart kubelet
+`, strings.TrimSpace(configYAML), kubeletConfigDropInPath, kubeletConfigDropInPath)
+}
+
+// mergeKubeletConfig combines the cluster-wide KubeletConfig defaults with
+// a machine's own overrides, preferring the machine's value for any field
+// it sets. The zero value of each field means "not set" for the purposes
+// of this merge.
+func mergeKubeletConfig(clusterDefault gceconfigv1.KubeletConfig, machineOverride gceconfigv1.KubeletConfig) gceconfigv1.KubeletConfig {
+	merged := clusterDefault
+
+	if machineOverride.PodInfraContainerImage != "" {
+		merged.PodInfraContainerImage = machineOverride.PodInfraContainerImage
+	}
+	if machineOverride.CgroupDriver != "" {
+		merged.CgroupDriver = machineOverride.CgroupDriver
+	}
+	if machineOverride.ClusterDNS != "" {
+		merged.ClusterDNS = machineOverride.ClusterDNS
+	}
+	if machineOverride.ClusterDomain != "" {
+		merged.ClusterDomain = machineOverride.ClusterDomain
+	}
+	if machineOverride.MaxPods != 0 {
+		merged.MaxPods = machineOverride.MaxPods
+	}
+	if len(machineOverride.FeatureGates) > 0 {
+		merged.FeatureGates = machineOverride.FeatureGates
+	}
+	if len(machineOverride.EvictionHard) > 0 {
+		merged.EvictionHard = machineOverride.EvictionHard
+	}
+
+	return merged
+}
+
+// kubeletConfigMetadata renders the merged KubeletConfig as the
+// KubeletConfiguration YAML the startup script writes to
+// /var/lib/kubelet/config.yaml and points --config at, returning the
+// single metadata key/value pair to add to the instance's metadata. Only
+// fields the cluster or machine actually set are included -- the zero
+// value of e.g. MaxPods means "unset", not "0", so emitting it verbatim
+// would override kubelet's own default (110) with "schedule nothing on
+// this node". If neither the cluster nor the machine set anything, no
+// metadata key is returned at all and the startup script falls back to
+// kubelet's built-in defaults exactly as it did before this config block
+// existed.
+func kubeletConfigMetadata(clusterConfig *gceconfigv1.GCEClusterProviderConfig, machineConfig *gceconfigv1.GCEMachineProviderConfig) (map[string]string, error) {
+	merged := mergeKubeletConfig(clusterConfig.KubeletConfig, machineConfig.KubeletConfig)
+
+	doc := map[string]interface{}{}
+	if merged.PodInfraContainerImage != "" {
+		doc["podInfraContainerImage"] = merged.PodInfraContainerImage
+	}
+	if merged.CgroupDriver != "" {
+		doc["cgroupDriver"] = merged.CgroupDriver
+	}
+	if merged.ClusterDNS != "" {
+		doc["clusterDNS"] = []string{merged.ClusterDNS}
+	}
+	if merged.ClusterDomain != "" {
+		doc["clusterDomain"] = merged.ClusterDomain
+	}
+	if len(merged.FeatureGates) > 0 {
+		doc["featureGates"] = merged.FeatureGates
+	}
+	if len(merged.EvictionHard) > 0 {
+		doc["evictionHard"] = merged.EvictionHard
+	}
+	if merged.MaxPods != 0 {
+		doc["maxPods"] = merged.MaxPods
+	}
+	if len(doc) == 0 {
+		return map[string]string{}, nil
+	}
+	doc["apiVersion"] = "kubelet.config.k8s.io/v1beta1"
+	doc["kind"] = "KubeletConfiguration"
+
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal merged KubeletConfig: %v", err)
+	}
+
+	return map[string]string{kubeletConfigMetadataKey: string(out)}, nil
+}
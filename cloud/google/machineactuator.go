@@ -32,8 +32,6 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 
-	"regexp"
-
 	"encoding/base64"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -59,6 +57,28 @@ const (
 	MachineSetupConfigsFilename = "machine_setup_configs.yaml"
 )
 
+// UpdateStrategy controls how GCEClient.Update rolls out changes to an
+// existing machine. It is read from GCEMachineProviderConfig.UpdateStrategy.
+type UpdateStrategy string
+
+const (
+	// InPlaceStrategy upgrades kubeadm/kubelet on the existing instance over
+	// SSH without destroying it.
+	InPlaceStrategy UpdateStrategy = "InPlace"
+	// RecreateStrategy provisions a replacement instance, waits for it to
+	// join the cluster, drains the old node, and only then deletes it.
+	RecreateStrategy UpdateStrategy = "Recreate"
+
+	// surgeNameSuffix is appended to the machine name when provisioning the
+	// replacement instance for a RecreateStrategy update.
+	surgeNameSuffix = "-surge"
+
+	// nodeReadyTimeout bounds how long we wait for a surge instance to
+	// register as Ready before giving up and rolling back.
+	nodeReadyTimeout = time.Minute * 10
+	nodeReadyPoll    = time.Second * 10
+)
+
 type SshCreds struct {
 	user           string
 	privateKeyPath string
@@ -74,7 +94,17 @@ type GCEClientComputeService interface {
 	InstancesDelete(project string, zone string, targetInstance string) (*compute.Operation, error)
 	InstancesGet(project string, zone string, instance string) (*compute.Instance, error)
 	InstancesInsert(project string, zone string, instance *compute.Instance) (*compute.Operation, error)
+	GetIP(project string, zone string, instance string) (string, error)
+	DisksGet(project string, zone string, name string) (*compute.Disk, error)
+	InstancesList(project string, zone string, filter string) ([]*compute.Instance, error)
 	ZoneOperationsGet(project string, zone string, operation string) (*compute.Operation, error)
+	ZonesList(project string, region string) ([]*compute.Zone, error)
+	InstanceTemplatesGet(project string, template string) (*compute.InstanceTemplate, error)
+	InstanceTemplatesInsert(project string, template *compute.InstanceTemplate) (*compute.Operation, error)
+	InstanceGroupManagersGet(project string, zone string, name string) (*compute.InstanceGroupManager, error)
+	InstanceGroupManagersInsert(project string, zone string, mig *compute.InstanceGroupManager) (*compute.Operation, error)
+	InstanceGroupManagersPatch(project string, zone string, name string, mig *compute.InstanceGroupManager) (*compute.Operation, error)
+	InstanceGroupManagersListManagedInstances(project string, zone string, name string) ([]*compute.ManagedInstance, error)
 }
 
 type GCEClient struct {
@@ -86,12 +116,33 @@ type GCEClient struct {
 	sshCreds                 SshCreds
 	machineClient            client.MachineInterface
 	machineSetupConfigGetter GCEClientMachineSetupConfigGetter
+	safetyOrphanVMPeriod     time.Duration
+	safetyOvershootPeriod    time.Duration
+	preemptionPollPeriod     time.Duration
+	imageResolverCache       imageCache
+	zoneRoundRobin           zoneRoundRobin
 }
 
 type MachineActuatorParams struct {
-	CertificateAuthority     *cert.CertificateAuthority
-	ComputeService           GCEClientComputeService
-	KubeadmToken             string
+	CertificateAuthority *cert.CertificateAuthority
+	ComputeService       GCEClientComputeService
+	// ComputeServiceEndpoint, when set to a "grpc://" unix socket address,
+	// selects the out-of-tree MachineService driver as the
+	// GCEClientComputeService implementation instead of the in-process GCE
+	// client. Ignored if ComputeService is already set.
+	ComputeServiceEndpoint string
+	KubeadmToken           string
+	// SafetyOrphanVMPeriod is how often the safety controller looks for GCE
+	// instances with no corresponding Machine object. Zero disables the
+	// orphan-VM loop.
+	SafetyOrphanVMPeriod time.Duration
+	// SafetyOvershootPeriod is how often the safety controller compares
+	// live instance counts against the Machines requesting them. Zero
+	// disables the overshoot loop.
+	SafetyOvershootPeriod time.Duration
+	// PreemptionPollPeriod is how often the interruption handler checks for
+	// preempted instances. Zero disables the loop.
+	PreemptionPollPeriod     time.Duration
 	MachineClient            client.MachineInterface
 	MachineSetupConfigGetter GCEClientMachineSetupConfigGetter
 }
@@ -141,6 +192,9 @@ func NewMachineActuator(params MachineActuatorParams) (*GCEClient, error) {
 		},
 		machineClient:            params.MachineClient,
 		machineSetupConfigGetter: params.MachineSetupConfigGetter,
+		safetyOrphanVMPeriod:     params.SafetyOrphanVMPeriod,
+		safetyOvershootPeriod:    params.SafetyOvershootPeriod,
+		preemptionPollPeriod:     params.PreemptionPollPeriod,
 	}, nil
 }
 
@@ -214,6 +268,49 @@ func (gce *GCEClient) Create(cluster *clusterv1.Cluster, machine *clusterv1.Mach
 		return gce.handleMachineError(machine, verr)
 	}
 
+	instance, err := gce.instanceIfExists(cluster, machine)
+	if err != nil {
+		return err
+	}
+
+	zone, err := gce.selectZone(cluster, machine, clusterConfig, machineConfig)
+	if err != nil {
+		return gce.handleMachineError(machine, apierrors.CreateMachine(
+			"cannot select a zone for machine: %v", err))
+	}
+	if machine.ObjectMeta.Annotations == nil {
+		machine.ObjectMeta.Annotations = make(map[string]string)
+	}
+	machine.ObjectMeta.Annotations[ZoneAnnotationKey] = zone
+
+	if instance == nil {
+		if err := gce.insertInstance(cluster, machine, clusterConfig, machineConfig, machine.ObjectMeta.Name, zone); err != nil {
+			return err
+		}
+
+		// If we have a machineClient, then annotate the machine so that we
+		// remember exactly what VM we created for it.
+		if gce.machineClient != nil {
+			return gce.updateAnnotations(cluster, machine)
+		}
+	} else {
+		glog.Infof("Skipped creating a VM that already exists.\n")
+	}
+
+	return nil
+}
+
+// insertInstance resolves machine's image and metadata and calls
+// computeService.InstancesInsert to provision name in zone. It does not
+// touch machine's persisted state in the API server -- callers that want
+// the usual Create() bookkeeping (ResolvedImageAnnotationKey,
+// updateAnnotations) do that themselves; updateWithSurge calls this
+// directly for its ephemeral, never-persisted surge copy of a Machine.
+func (gce *GCEClient) insertInstance(cluster *clusterv1.Cluster, machine *clusterv1.Machine, clusterConfig *gceconfigv1.GCEClusterProviderConfig, machineConfig *gceconfigv1.GCEMachineProviderConfig, name string, zone string) error {
+	if verr := validateInstanceSecurityConfig(machineConfig); verr != nil {
+		return gce.handleMachineError(machine, verr)
+	}
+
 	configParams := &machinesetup.ConfigParams{
 		OS:       machineConfig.OS,
 		Roles:    machine.Spec.Roles,
@@ -227,76 +324,81 @@ func (gce *GCEClient) Create(cluster *clusterv1.Cluster, machine *clusterv1.Mach
 	if err != nil {
 		return err
 	}
-	imagePath := gce.getImagePath(image)
-	metadata, err := gce.getMetadata(cluster, machine, clusterConfig, configParams)
+	imagePath, err := gce.resolveImage(clusterConfig.Project, image)
 	if err != nil {
-		return err
+		return gce.handleMachineError(machine, apierrors.InvalidMachineConfiguration(
+			"cannot resolve machine image %q: %v", image, err))
+	}
+	if machine.ObjectMeta.Annotations == nil {
+		machine.ObjectMeta.Annotations = make(map[string]string)
 	}
+	machine.ObjectMeta.Annotations[ResolvedImageAnnotationKey] = imagePath
 
-	instance, err := gce.instanceIfExists(cluster, machine)
+	metadata, err := gce.getMetadata(cluster, machine, clusterConfig, configParams)
 	if err != nil {
 		return err
 	}
 
-	name := machine.ObjectMeta.Name
-	project := clusterConfig.Project
-	zone := machineConfig.Zone
-
-	if instance == nil {
-		labels := map[string]string{}
-		if gce.machineClient == nil {
-			labels[BootstrapLabelKey] = "true"
+	existingBootDisk := ""
+	if machineConfig.PreserveBootDiskOnRecreate {
+		// GCE names an instance's unnamed boot disk after the instance
+		// itself, so the disk left behind by a prior deleteInstance (which
+		// sets AutoDelete=false for exactly this case) is still sitting
+		// around under this same name -- reattach it instead of
+		// initializing a fresh disk from the image.
+		if disk, err := gce.computeService.DisksGet(clusterConfig.Project, zone, name); err == nil && disk != nil {
+			existingBootDisk = disk.SelfLink
 		}
+	}
+
+	labels := map[string]string{}
+	if gce.machineClient == nil {
+		labels[BootstrapLabelKey] = "true"
+	}
 
-		op, err := gce.computeService.InstancesInsert(project, zone, &compute.Instance{
-			Name:         name,
-			MachineType:  fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineConfig.MachineType),
-			CanIpForward: true,
-			NetworkInterfaces: []*compute.NetworkInterface{
-				{
-					Network: "global/networks/default",
-					AccessConfigs: []*compute.AccessConfig{
-						{
-							Type: "ONE_TO_ONE_NAT",
-							Name: "External NAT",
-						},
+	op, err := gce.computeService.InstancesInsert(clusterConfig.Project, zone, &compute.Instance{
+		Name:         name,
+		MachineType:  fmt.Sprintf("zones/%s/machineTypes/%s", zone, machineConfig.MachineType),
+		CanIpForward: true,
+		NetworkInterfaces: []*compute.NetworkInterface{
+			{
+				Network: "global/networks/default",
+				AccessConfigs: []*compute.AccessConfig{
+					{
+						Type: "ONE_TO_ONE_NAT",
+						Name: "External NAT",
 					},
 				},
 			},
-			Disks: newDisks(machineConfig, zone, imagePath, int64(30)),
-			Metadata: metadata,
-			Tags: &compute.Tags{
-				Items: []string{
-					"https-server",
-					fmt.Sprintf("%s-worker", cluster.Name)},
-			},
-			Labels: labels,
-			ServiceAccounts: []*compute.ServiceAccount{
-				{
-					Email: gce.GetDefaultServiceAccountForMachine(cluster, machine),
-					Scopes: []string{
-						compute.CloudPlatformScope,
-					},
+		},
+		Disks:    newDisks(machineConfig, zone, imagePath, int64(30), existingBootDisk),
+		Metadata: metadata,
+		Tags: &compute.Tags{
+			Items: []string{
+				"https-server",
+				fmt.Sprintf("%s-worker", cluster.Name)},
+		},
+		Labels:                     labels,
+		Scheduling:                 schedulingFor(machineConfig),
+		ShieldedInstanceConfig:     shieldedInstanceConfigFor(machineConfig),
+		ConfidentialInstanceConfig: confidentialInstanceConfigFor(machineConfig),
+		ServiceAccounts: []*compute.ServiceAccount{
+			{
+				Email: gce.GetDefaultServiceAccountForMachine(cluster, machine),
+				Scopes: []string{
+					compute.CloudPlatformScope,
 				},
 			},
-		})
-
-		if err == nil {
-			err = gce.waitForOperation(clusterConfig, op)
-		}
+		},
+	})
 
-		if err != nil {
-			return gce.handleMachineError(machine, apierrors.CreateMachine(
-				"error creating GCE instance: %v", err))
-		}
+	if err == nil {
+		err = gce.waitForOperation(clusterConfig, op)
+	}
 
-		// If we have a machineClient, then annotate the machine so that we
-		// remember exactly what VM we created for it.
-		if gce.machineClient != nil {
-			return gce.updateAnnotations(cluster, machine)
-		}
-	} else {
-		glog.Infof("Skipped creating a VM that already exists.\n")
+	if err != nil {
+		return gce.handleMachineError(machine, apierrors.CreateMachine(
+			"error creating GCE instance: %v", err))
 	}
 
 	return nil
@@ -329,19 +431,28 @@ func (gce *GCEClient) Delete(cluster *clusterv1.Cluster, machine *clusterv1.Mach
 		return gce.handleMachineError(machine, verr)
 	}
 
-	var project, zone, name string
+	project := clusterConfig.Project
+	zone := machineConfig.Zone
+	name := machine.ObjectMeta.Name
 
-	if machine.ObjectMeta.Annotations != nil {
-		project = machine.ObjectMeta.Annotations[ProjectAnnotationKey]
-		zone = machine.ObjectMeta.Annotations[ZoneAnnotationKey]
-		name = machine.ObjectMeta.Annotations[NameAnnotationKey]
+	// Each annotation falls back to providerConfig independently -- a
+	// Machine claimed from a Managed Instance Group only ever gets
+	// ZoneAnnotationKey set (see bindMIGInstances), so treating the three
+	// as all-or-nothing would discard its correct zone annotation just
+	// because Project/Name were never persisted for it.
+	if annotated := machine.ObjectMeta.Annotations[ProjectAnnotationKey]; annotated != "" {
+		project = annotated
 	}
-
-	// If the annotations are missing, fall back on providerConfig
-	if project == "" || zone == "" || name == "" {
-		project = clusterConfig.Project
-		zone = machineConfig.Zone
-		name = machine.ObjectMeta.Name
+	if annotated := machine.ObjectMeta.Annotations[ZoneAnnotationKey]; annotated != "" {
+		zone = annotated
+	}
+	if annotated := machine.ObjectMeta.Annotations[NameAnnotationKey]; annotated != "" {
+		name = annotated
+	}
+	// A Machine claimed from a Managed Instance Group is backed by a VM
+	// GCE named itself, not by machine.ObjectMeta.Name.
+	if migInstance, ok := migInstanceName(machine); ok {
+		name = migInstance
 	}
 
 	op, err := gce.computeService.InstancesDelete(project, zone, name)
@@ -435,24 +546,40 @@ func (gce *GCEClient) Update(cluster *clusterv1.Cluster, goalMachine *clusterv1.
 		return nil
 	}
 
-	if util.IsMaster(currentMachine) {
-		glog.Infof("Doing an in-place upgrade for master.\n")
+	strategy := config.UpdateStrategy
+	if strategy == "" {
+		// Preserve the historical behavior: masters upgrade in place,
+		// everything else is destroyed and recreated.
+		if util.IsMaster(currentMachine) {
+			strategy = InPlaceStrategy
+		} else {
+			strategy = RecreateStrategy
+		}
+	}
+	if gce.preemptibilityChanged(currentMachine, goalMachine) {
+		// Preemptible is a launch-time-only GCE setting; there is no
+		// in-place way to flip it on a running instance.
+		glog.Infof("Preemptible changed for %s, forcing %s regardless of configured UpdateStrategy.", goalMachine.ObjectMeta.Name, RecreateStrategy)
+		strategy = RecreateStrategy
+	}
+
+	switch strategy {
+	case InPlaceStrategy:
+		glog.Infof("Doing an in-place upgrade for %s.\n", goalMachine.ObjectMeta.Name)
 		// TODO: should we support custom CAs here?
-		err = gce.updateMasterInplace(cluster, currentMachine, goalMachine)
+		err = gce.updateInplace(cluster, currentMachine, goalMachine)
 		if err != nil {
-			glog.Errorf("master inplace update failed: %v", err)
+			glog.Errorf("in-place update failed: %v", err)
 		}
-	} else {
-		glog.Infof("re-creating machine %s for update.", currentMachine.ObjectMeta.Name)
-		err = gce.Delete(cluster, currentMachine)
+	case RecreateStrategy:
+		glog.Infof("surging a replacement for machine %s for update.", currentMachine.ObjectMeta.Name)
+		err = gce.updateWithSurge(cluster, currentMachine, goalMachine)
 		if err != nil {
-			glog.Errorf("delete machine %s for update failed: %v", currentMachine.ObjectMeta.Name, err)
-		} else {
-			err = gce.Create(cluster, goalMachine)
-			if err != nil {
-				glog.Errorf("create machine %s for update failed: %v", goalMachine.ObjectMeta.Name, err)
-			}
+			glog.Errorf("surge update of machine %s failed: %v", currentMachine.ObjectMeta.Name, err)
 		}
+	default:
+		return gce.handleMachineError(goalMachine, apierrors.InvalidMachineConfiguration(
+			"unknown update strategy %q", strategy))
 	}
 	if err != nil {
 		return err
@@ -480,21 +607,16 @@ func (gce *GCEClient) GetIP(cluster *clusterv1.Cluster, machine *clusterv1.Machi
 		return "", err
 	}
 
-	instance, err := gce.computeService.InstancesGet(clusterConfig.Project, machineConfig.Zone, machine.ObjectMeta.Name)
-	if err != nil {
-		return "", err
+	zone := machineConfig.Zone
+	if annotatedZone := machine.ObjectMeta.Annotations[ZoneAnnotationKey]; annotatedZone != "" {
+		zone = annotatedZone
 	}
-
-	var publicIP string
-
-	for _, networkInterface := range instance.NetworkInterfaces {
-		if networkInterface.Name == "nic0" {
-			for _, accessConfigs := range networkInterface.AccessConfigs {
-				publicIP = accessConfigs.NatIP
-			}
-		}
+	name := machine.ObjectMeta.Name
+	if migInstance, ok := migInstanceName(machine); ok {
+		name = migInstance
 	}
-	return publicIP, nil
+
+	return gce.computeService.GetIP(clusterConfig.Project, zone, name)
 }
 
 func (gce *GCEClient) GetKubeConfig(cluster *clusterv1.Cluster, master *clusterv1.Machine) (string, error) {
@@ -518,7 +640,10 @@ func (gce *GCEClient) GetKubeConfig(cluster *clusterv1.Cluster, master *clusterv
 func (gce *GCEClient) updateAnnotations(cluster *clusterv1.Cluster, machine *clusterv1.Machine) error {
 	machineConfig, err := gce.machineproviderconfig(machine.Spec.ProviderConfig)
 	name := machine.ObjectMeta.Name
-	zone := machineConfig.Zone
+	zone := machine.ObjectMeta.Annotations[ZoneAnnotationKey]
+	if zone == "" {
+		zone = machineConfig.Zone
+	}
 	if err != nil {
 		return gce.handleMachineError(machine,
 			apierrors.InvalidMachineConfiguration("Cannot unmarshal machine's providerConfig field: %v", err))
@@ -545,6 +670,23 @@ func (gce *GCEClient) updateAnnotations(cluster *clusterv1.Cluster, machine *clu
 	return err
 }
 
+// preemptibilityChanged reports whether a and b's providerConfig disagree
+// on Scheduling.Preemptible or Scheduling.ProvisioningModel. GCE only
+// honors these at instance creation time, so they can never be reconciled
+// in place.
+func (gce *GCEClient) preemptibilityChanged(a *clusterv1.Machine, b *clusterv1.Machine) bool {
+	aConfig, err := gce.machineproviderconfig(a.Spec.ProviderConfig)
+	if err != nil {
+		return false
+	}
+	bConfig, err := gce.machineproviderconfig(b.Spec.ProviderConfig)
+	if err != nil {
+		return false
+	}
+	return aConfig.Scheduling.Preemptible != bConfig.Scheduling.Preemptible ||
+		aConfig.Scheduling.ProvisioningModel != bConfig.Scheduling.ProvisioningModel
+}
+
 // The two machines differ in a way that requires an update
 func (gce *GCEClient) requiresUpdate(a *clusterv1.Machine, b *clusterv1.Machine) bool {
 	// Do not want status changes. Do want changes that impact machine provisioning
@@ -581,7 +723,18 @@ func (gce *GCEClient) instanceIfExists(cluster *clusterv1.Cluster, machine *clus
 		return nil, err
 	}
 
-	instance, err := gce.computeService.InstancesGet(clusterConfig.Project, machineConfig.Zone, identifyingMachine.ObjectMeta.Name)
+	name := identifyingMachine.ObjectMeta.Name
+	zone := machineConfig.Zone
+	// A Machine claimed from a Managed Instance Group is backed by a VM
+	// GCE named itself, not by machine.ObjectMeta.Name.
+	if migInstance, ok := migInstanceName(identifyingMachine); ok {
+		name = migInstance
+	}
+	if annotatedZone := identifyingMachine.ObjectMeta.Annotations[ZoneAnnotationKey]; annotatedZone != "" {
+		zone = annotatedZone
+	}
+
+	instance, err := gce.computeService.InstancesGet(clusterConfig.Project, zone, name)
 	if err != nil {
 		// TODO: Use formal way to check for error code 404
 		if strings.Contains(err.Error(), "Error 404") {
@@ -652,43 +805,59 @@ func (gce *GCEClient) checkOp(op *compute.Operation, err error) error {
 	return errors.New(errs.String())
 }
 
-func (gce *GCEClient) updateMasterInplace(cluster *clusterv1.Cluster, oldMachine *clusterv1.Machine, newMachine *clusterv1.Machine) error {
-	if oldMachine.Spec.Versions.ControlPlane != newMachine.Spec.Versions.ControlPlane {
-		cmd := fmt.Sprintf(
-			"curl -sSL https://dl.k8s.io/release/v%s/bin/linux/amd64/kubeadm | sudo tee /usr/bin/kubeadm > /dev/null; " +
-			"sudo chmod a+rx /usr/bin/kubeadm", newMachine.Spec.Versions.ControlPlane)
-		_, err := gce.remoteSshCommand(cluster, newMachine, cmd)
+// updateInplace upgrades kubeadm/kubelet on the existing instance over SSH,
+// skipping the control-plane upgrade steps for non-master machines.
+func (gce *GCEClient) updateInplace(cluster *clusterv1.Cluster, oldMachine *clusterv1.Machine, newMachine *clusterv1.Machine) error {
+	return gce.upgradeKubeadmAndKubelet(cluster, oldMachine, newMachine, !util.IsMaster(newMachine))
+}
+
+// upgradeKubeadmAndKubelet runs the control-plane and kubelet upgrades
+// against newMachine through its BootstrapProvider, so the actual commands
+// depend on the machine's OS rather than assuming kubeadm-on-Ubuntu. When
+// skipControlPlane is true, the control-plane upgrade step is skipped,
+// leaving only the kubelet upgrade -- this is what lets workers share the
+// same upgrade path as masters under InPlaceStrategy.
+func (gce *GCEClient) upgradeKubeadmAndKubelet(cluster *clusterv1.Cluster, oldMachine *clusterv1.Machine, newMachine *clusterv1.Machine, skipControlPlane bool) error {
+	machineConfig, err := gce.machineproviderconfig(newMachine.Spec.ProviderConfig)
+	if err != nil {
+		return gce.handleMachineError(newMachine,
+			apierrors.InvalidMachineConfiguration("Cannot unmarshal machine's providerConfig field: %v", err))
+	}
+	provider := gce.bootstrapProvider(machineConfig.OS, "", machinesetup.Metadata{}, "")
+	ssh := func(cmd string) (string, error) {
+		out, err := gce.remoteSshCommand(cluster, newMachine, cmd)
 		if err != nil {
 			glog.Infof("remotesshcomand error: %v", err)
-			return err
 		}
+		return out, err
+	}
+	ctx := context.Background()
 
-		// TODO: We might want to upgrade kubeadm if the target control plane version is newer.
-		// Upgrade control plan.
-		cmd = fmt.Sprintf("sudo kubeadm upgrade apply %s -y", "v"+newMachine.Spec.Versions.ControlPlane)
-		_, err = gce.remoteSshCommand(cluster, newMachine, cmd)
-		if err != nil {
-			glog.Infof("remotesshcomand error: %v", err)
+	if !skipControlPlane && oldMachine.Spec.Versions.ControlPlane != newMachine.Spec.Versions.ControlPlane {
+		if err := provider.UpgradeControlPlane(ctx, ssh, oldMachine.Spec.Versions.ControlPlane, newMachine.Spec.Versions.ControlPlane); err != nil {
 			return err
 		}
 	}
 
-	// Upgrade kubelet.
+	// Upgrade kubelet. Unlike the control-plane upgrade above, drain and
+	// uncordon have to run against the API server rather than over SSH into
+	// newMachine itself: /etc/kubernetes/admin.conf only exists on masters,
+	// so that command silently no-ops when draining a worker and hard-fails
+	// on uncordon. A master machine always has admin.conf, so its
+	// kubeconfig is fetched and used to run kubectl locally instead.
 	if oldMachine.Spec.Versions.Kubelet != newMachine.Spec.Versions.Kubelet {
-		cmd := fmt.Sprintf("sudo kubectl drain %s --kubeconfig /etc/kubernetes/admin.conf --ignore-daemonsets", newMachine.Name)
-		// The errors are intentionally ignored as master has static pods.
-		gce.remoteSshCommand(cluster, newMachine, cmd)
-		// Upgrade kubelet to desired version.
-		cmd = fmt.Sprintf("sudo apt-get install kubelet=%s", newMachine.Spec.Versions.Kubelet+"-00")
-		_, err := gce.remoteSshCommand(cluster, newMachine, cmd)
+		master, err := gce.masterMachineFor(cluster, newMachine)
 		if err != nil {
-			glog.Infof("remotesshcomand error: %v", err)
+			return fmt.Errorf("cannot find a master to drain/uncordon %s through: %v", newMachine.Name, err)
+		}
+		// The error is intentionally ignored: a master has static pods that
+		// drain will refuse to evict, which is expected and not fatal here.
+		gce.runKubectlAsMaster(cluster, master, "drain", newMachine.Name, "--ignore-daemonsets")
+		if err := provider.UpgradeKubelet(ctx, ssh, newMachine.Spec.Versions.Kubelet); err != nil {
 			return err
 		}
-		cmd = fmt.Sprintf("sudo kubectl uncordon %s --kubeconfig /etc/kubernetes/admin.conf", newMachine.Name)
-		_, err = gce.remoteSshCommand(cluster, newMachine, cmd)
-		if err != nil {
-			glog.Infof("remotesshcomand error: %v", err)
+		if _, err := gce.runKubectlAsMaster(cluster, master, "uncordon", newMachine.Name); err != nil {
+			glog.Infof("failed to uncordon %s: %v", newMachine.Name, err)
 			return err
 		}
 	}
@@ -696,6 +865,175 @@ func (gce *GCEClient) updateMasterInplace(cluster *clusterv1.Cluster, oldMachine
 	return nil
 }
 
+// masterMachineFor finds a control-plane Machine belonging to cluster,
+// suitable for fetching a kubeconfig to run kubectl against on behalf of
+// machine. Returns an error if no master can be found, e.g. because
+// gce.machineClient is nil.
+func (gce *GCEClient) masterMachineFor(cluster *clusterv1.Cluster, machine *clusterv1.Machine) (*clusterv1.Machine, error) {
+	if util.IsMaster(machine) {
+		return machine, nil
+	}
+	if gce.machineClient == nil {
+		return nil, fmt.Errorf("no machineClient available to look up a master for cluster %s", cluster.Name)
+	}
+	machines, err := gce.machineClient.List(metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list machines for cluster %s: %v", cluster.Name, err)
+	}
+	for i := range machines.Items {
+		if util.IsMaster(&machines.Items[i]) {
+			return &machines.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no master machine found for cluster %s", cluster.Name)
+}
+
+// runKubectlAsMaster fetches master's kubeconfig the same way GetKubeConfig
+// does and runs kubectl against it locally with args, so drain/uncordon of
+// another node works whether or not that node itself has admin.conf.
+func (gce *GCEClient) runKubectlAsMaster(cluster *clusterv1.Cluster, master *clusterv1.Machine, args ...string) (string, error) {
+	kubeconfig, err := gce.GetKubeConfig(cluster, master)
+	if err != nil {
+		return "", fmt.Errorf("could not fetch master kubeconfig: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile("", "kubectl-kubeconfig")
+	if err != nil {
+		return "", fmt.Errorf("could not create temp kubeconfig: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(kubeconfig); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("could not write temp kubeconfig: %v", err)
+	}
+	tmp.Close()
+
+	out := util.ExecCommand("kubectl", append([]string{"--kubeconfig", tmp.Name()}, args...)...)
+	return out, nil
+}
+
+// updateWithSurge implements the RecreateStrategy create-before-delete flow:
+// the replacement instance is provisioned under a suffixed name, and only
+// once it has registered as Ready is the old node cordoned, drained, and
+// deleted. If anything fails along the way, the surge instance is torn down
+// and the original is left intact.
+func (gce *GCEClient) updateWithSurge(cluster *clusterv1.Cluster, oldMachine *clusterv1.Machine, newMachine *clusterv1.Machine) error {
+	clusterConfig, err := gce.clusterproviderconfig(cluster.Spec.ProviderConfig)
+	if err != nil {
+		return gce.handleMachineError(newMachine,
+			apierrors.InvalidMachineConfiguration("Cannot unmarshal cluster's providerConfig field: %v", err))
+	}
+	machineConfig, err := gce.machineproviderconfig(newMachine.Spec.ProviderConfig)
+	if err != nil {
+		return gce.handleMachineError(newMachine,
+			apierrors.InvalidMachineConfiguration("Cannot unmarshal machine's providerConfig field: %v", err))
+	}
+
+	surgeMachine := newMachine.DeepCopy()
+	surgeMachine.ObjectMeta.Name = oldMachine.ObjectMeta.Name + surgeNameSuffix
+
+	surgeZone, err := gce.selectZone(cluster, surgeMachine, clusterConfig, machineConfig)
+	if err != nil {
+		return fmt.Errorf("cannot select a zone for surge instance: %v", err)
+	}
+
+	// surgeMachine is never persisted as a Machine object in the API
+	// server -- it's an ephemeral stand-in used only to drive
+	// provisioning and readiness checks -- so it is provisioned directly
+	// via insertInstance instead of Create(), which would try to
+	// machineClient.Update() a Machine name that doesn't exist.
+	glog.Infof("creating surge instance %s to replace %s", surgeMachine.ObjectMeta.Name, oldMachine.ObjectMeta.Name)
+	if err := gce.insertInstance(cluster, surgeMachine, clusterConfig, machineConfig, surgeMachine.ObjectMeta.Name, surgeZone); err != nil {
+		return fmt.Errorf("failed to create surge instance %s: %v", surgeMachine.ObjectMeta.Name, err)
+	}
+
+	if err := gce.waitForNodeReady(cluster, oldMachine, surgeMachine); err != nil {
+		glog.Errorf("surge instance %s never became Ready, rolling back: %v", surgeMachine.ObjectMeta.Name, err)
+		if delErr := gce.deleteInstance(clusterConfig, surgeZone, surgeMachine.ObjectMeta.Name); delErr != nil {
+			glog.Errorf("failed to delete surge instance %s during rollback: %v", surgeMachine.ObjectMeta.Name, delErr)
+		}
+		return err
+	}
+
+	cmd := fmt.Sprintf("sudo kubectl drain %s --kubeconfig /etc/kubernetes/admin.conf --ignore-daemonsets --delete-local-data", oldMachine.Name)
+	if _, err := gce.remoteSshCommand(cluster, oldMachine, cmd); err != nil {
+		glog.Errorf("failed to cordon/drain old node %s: %v", oldMachine.Name, err)
+	}
+
+	if err := gce.Delete(cluster, oldMachine); err != nil {
+		return fmt.Errorf("surge instance %s is Ready, but deleting old instance %s failed: %v", surgeMachine.ObjectMeta.Name, oldMachine.ObjectMeta.Name, err)
+	}
+
+	// newMachine is the real, persisted Machine -- it must now point at the
+	// surge instance, or every later instanceIfExists/GetIP/Delete call
+	// keeps looking up the original (now-deleted) name, leaking the surge
+	// VM and causing Create to provision a duplicate on the next reconcile.
+	if newMachine.ObjectMeta.Annotations == nil {
+		newMachine.ObjectMeta.Annotations = make(map[string]string)
+	}
+	newMachine.ObjectMeta.Annotations[ProjectAnnotationKey] = clusterConfig.Project
+	newMachine.ObjectMeta.Annotations[ZoneAnnotationKey] = surgeZone
+	newMachine.ObjectMeta.Annotations[NameAnnotationKey] = surgeMachine.ObjectMeta.Name
+	if gce.machineClient != nil {
+		if _, err := gce.machineClient.Update(newMachine); err != nil {
+			return fmt.Errorf("surge instance %s is Ready and old instance deleted, but failed to persist its name onto machine %s: %v", surgeMachine.ObjectMeta.Name, newMachine.ObjectMeta.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForNodeReady polls the cluster's kubeconfig, fetched the same way as
+// GetKubeConfig, until surgeMachine's node has registered as Ready.
+func (gce *GCEClient) waitForNodeReady(cluster *clusterv1.Cluster, master *clusterv1.Machine, surgeMachine *clusterv1.Machine) error {
+	kubeconfig, err := gce.GetKubeConfig(cluster, master)
+	if err != nil {
+		return fmt.Errorf("could not fetch kubeconfig to verify surge instance readiness: %v", err)
+	}
+
+	start := time.Now()
+	for {
+		if isNodeReady(kubeconfig, surgeMachine.ObjectMeta.Name) {
+			return nil
+		}
+		if time.Since(start) > nodeReadyTimeout {
+			return fmt.Errorf("timed out after %v waiting for node %s to become Ready", nodeReadyTimeout, surgeMachine.ObjectMeta.Name)
+		}
+		time.Sleep(nodeReadyPoll)
+	}
+}
+
+// isNodeReady shells out to kubectl using the supplied kubeconfig content to
+// check whether nodeName has registered and is in the Ready condition.
+func isNodeReady(kubeconfig string, nodeName string) bool {
+	tmp, err := ioutil.TempFile("", "surge-kubeconfig")
+	if err != nil {
+		glog.Errorf("could not create temp kubeconfig: %v", err)
+		return false
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(kubeconfig); err != nil {
+		glog.Errorf("could not write temp kubeconfig: %v", err)
+		return false
+	}
+	tmp.Close()
+
+	out := util.ExecCommand("kubectl", "--kubeconfig", tmp.Name(), "get", "node", nodeName,
+		"-o", "jsonpath={.status.conditions[?(@.type==\"Ready\")].status}")
+	return strings.TrimSpace(out) == "True"
+}
+
+// deleteInstance deletes the named GCE instance directly, bypassing the
+// Machine-object bookkeeping in Delete. Used to roll back a surge instance
+// that never needs (and may not have) a corresponding Machine.
+func (gce *GCEClient) deleteInstance(clusterConfig *gceconfigv1.GCEClusterProviderConfig, zone string, name string) error {
+	op, err := gce.computeService.InstancesDelete(clusterConfig.Project, zone, name)
+	if err == nil {
+		err = gce.waitForOperation(clusterConfig, op)
+	}
+	return err
+}
+
 func (gce *GCEClient) validateMachine(machine *clusterv1.Machine, config *gceconfigv1.GCEMachineProviderConfig) *apierrors.MachineError {
 	if machine.Spec.Versions.Kubelet == "" {
 		return apierrors.InvalidMachineConfiguration("spec.versions.kubelet can't be empty")
@@ -720,41 +1058,32 @@ func (gce *GCEClient) handleMachineError(machine *clusterv1.Machine, err *apierr
 	return err
 }
 
-func (gce *GCEClient) getImagePath(img string) (imagePath string) {
-	defaultImg := "projects/ubuntu-os-cloud/global/images/family/ubuntu-1710"
-
-	// A full image path must match the regex format. If it doesn't, we will fall back to a default base image.
-	matches := regexp.MustCompile("projects/(.+)/global/images/(family/)*(.+)").FindStringSubmatch(img)
-	if matches != nil {
-		// Check to see if the image exists in the given path. The presence of "family" in the path dictates which API call we need to make.
-		project, family, name := matches[1], matches[2], matches[3]
-		var err error
-		if family == "" {
-			_, err = gce.computeService.ImagesGet(project, name)
-		} else {
-			_, err = gce.computeService.ImagesGetFromFamily(project, name)
-		}
-
-		if err == nil {
-			return img
-		}
-	}
-
-	// Otherwise, fall back to the base image.
-	glog.Infof("Could not find image at %s. Defaulting to %s.", img, defaultImg)
-	return defaultImg
-}
-
-func newDisks(config *gceconfigv1.GCEMachineProviderConfig, zone string, imagePath string, minDiskSizeGb int64) []*compute.AttachedDisk {
+// newDisks builds the AttachedDisks for a new instance. existingBootDisk, if
+// non-empty, is the SelfLink of a boot disk preserved from this same
+// machine's prior incarnation (see insertInstance); it is attached by
+// Source instead of initialized fresh from imagePath, which is what makes
+// PreserveBootDiskOnRecreate actually preserve the disk's contents rather
+// than just outliving the old instance unattached.
+func newDisks(config *gceconfigv1.GCEMachineProviderConfig, zone string, imagePath string, minDiskSizeGb int64, existingBootDisk string) []*compute.AttachedDisk {
 	var disks []*compute.AttachedDisk
 	for idx, disk := range config.Disks {
 		diskSizeGb := disk.InitializeParams.DiskSizeGb
 		d := compute.AttachedDisk{
-			AutoDelete: true,
-			InitializeParams: &compute.AttachedDiskInitializeParams{
-				DiskSizeGb:  diskSizeGb,
-				DiskType:    fmt.Sprintf("zones/%s/diskTypes/%s", zone, disk.InitializeParams.DiskType),
-			},
+			AutoDelete:        true,
+			DiskEncryptionKey: diskEncryptionKeyFor(disk.InitializeParams.KmsKeyName),
+		}
+		if idx == 0 && existingBootDisk != "" {
+			d.Source = existingBootDisk
+			d.Boot = true
+			// A preserved disk keeps its own AutoDelete=false until the
+			// machine goes away for good; it was already leaked otherwise.
+			d.AutoDelete = false
+			disks = append(disks, &d)
+			continue
+		}
+		d.InitializeParams = &compute.AttachedDiskInitializeParams{
+			DiskSizeGb: diskSizeGb,
+			DiskType:   fmt.Sprintf("zones/%s/diskTypes/%s", zone, disk.InitializeParams.DiskType),
 		}
 		if idx == 0 {
 			d.InitializeParams.SourceImage = imagePath
@@ -763,12 +1092,40 @@ func newDisks(config *gceconfigv1.GCEMachineProviderConfig, zone string, imagePa
 				glog.Info("increasing disk size to %v gb, the supplied disk size of %v gb is below the minimum", minDiskSizeGb, diskSizeGb)
 				d.InitializeParams.DiskSizeGb = minDiskSizeGb
 			}
+			// A preemptible machine that wants to be recreated in place
+			// with its identity intact needs its boot disk to survive the
+			// instance being deleted out from under it.
+			if config.PreserveBootDiskOnRecreate {
+				d.AutoDelete = false
+			}
 		}
 		disks = append(disks, &d)
 	}
 	return disks
 }
 
+// schedulingFor translates GCEMachineProviderConfig.Scheduling into the
+// compute.Scheduling GCE requires. Preemptible/Spot instances cannot have
+// automatic restart, and omitting Scheduling entirely is equivalent to a
+// standard, auto-restarting instance.
+func schedulingFor(config *gceconfigv1.GCEMachineProviderConfig) *compute.Scheduling {
+	s := config.Scheduling
+	if !s.Preemptible && s.ProvisioningModel != "SPOT" {
+		return nil
+	}
+	automaticRestart := false
+	if s.AutomaticRestart != nil {
+		automaticRestart = *s.AutomaticRestart
+	}
+	return &compute.Scheduling{
+		Preemptible:               true,
+		AutomaticRestart:          &automaticRestart,
+		OnHostMaintenance:         s.OnHostMaintenance,
+		InstanceTerminationAction: s.InstanceTerminationAction,
+		ProvisioningModel:         s.ProvisioningModel,
+	}
+}
+
 // Just a temporary hack to grab a single range from the config.
 func getSubnet(netRange clusterv1.NetworkRanges) string {
 	if len(netRange.CIDRBlocks) == 0 {
@@ -781,6 +1138,9 @@ func getOrNewComputeService(params MachineActuatorParams) (GCEClientComputeServi
 	if params.ComputeService != nil {
 		return params.ComputeService, nil
 	}
+	if strings.HasPrefix(params.ComputeServiceEndpoint, grpcSocketPrefix) {
+		return newGRPCComputeService(params.ComputeServiceEndpoint)
+	}
 	// The default GCP client expects the environment variable
 	// GOOGLE_APPLICATION_CREDENTIALS to point to a file with service credentials.
 	client, err := google.DefaultClient(context.TODO(), compute.ComputeScope)
@@ -807,30 +1167,42 @@ func (gce *GCEClient) getMetadata(cluster *clusterv1.Cluster, machine *clusterv1
 	if err != nil {
 		return nil, err
 	}
+
+	role := nodeRole
 	if util.IsMaster(machine) {
+		role = masterRole
 		if machine.Spec.Versions.ControlPlane == "" {
 			return nil, gce.handleMachineError(machine, apierrors.InvalidMachineConfiguration(
 				"invalid master configuration: missing Machine.Spec.Versions.ControlPlane"))
 		}
-		var err error
-		metadataMap, err = masterMetadata(gce.kubeadmToken, cluster, machine, clusterConfig.Project, &machineSetupMetadata)
-		if err != nil {
-			return nil, err
-		}
+	} else if len(cluster.Status.APIEndpoints) == 0 {
+		return nil, errors.New("invalid cluster state: cannot create a Kubernetes node without an API endpoint")
+	}
+
+	machineConfig, err := gce.machineproviderconfig(machine.Spec.ProviderConfig)
+	if err != nil {
+		return nil, err
+	}
+	kubeletMetadata, err := kubeletConfigMetadata(clusterConfig, machineConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := gce.bootstrapProvider(configParams.OS, clusterConfig.Project, machineSetupMetadata, kubeletMetadata[kubeletConfigMetadataKey])
+	metadataMap, err = provider.GenerateJoinMetadata(cluster, machine, role)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range kubeletMetadata {
+		metadataMap[k] = v
+	}
+
+	if role == masterRole {
 		ca := gce.certificateAuthority
 		if ca != nil {
 			metadataMap["ca-cert"] = base64.StdEncoding.EncodeToString(ca.Certificate)
 			metadataMap["ca-key"] = base64.StdEncoding.EncodeToString(ca.PrivateKey)
 		}
-	} else {
-		if len(cluster.Status.APIEndpoints) == 0 {
-			return nil, errors.New("invalid cluster state: cannot create a Kubernetes node without an API endpoint")
-		}
-		var err error
-		metadataMap, err = nodeMetadata(gce.kubeadmToken, cluster, machine, clusterConfig.Project, &machineSetupMetadata)
-		if err != nil {
-			return nil, err
-		}
 	}
 	var metadataItems []*compute.MetadataItems
 	for k, v := range metadataMap {
@@ -848,7 +1220,6 @@ func (gce *GCEClient) getMetadata(cluster *clusterv1.Cluster, machine *clusterv1
 
 // TODO: We need to change this when we create dedicated service account for apiserver/controller
 // pod.
-//
 func CreateExtApiServerRoleBinding() error {
 	return run("kubectl", "create", "rolebinding",
 		"-n", "kube-system", "machine-controller", "--role=extension-apiserver-authentication-reader",
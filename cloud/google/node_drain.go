@@ -0,0 +1,75 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// drainNodeViaAPI cordons nodeName and evicts every non-daemonset pod from
+// it using the Kubernetes eviction API, rather than shelling out to
+// "kubectl drain" over SSH. This is what the preemption handler uses when
+// it already holds a clientset for the target cluster; callers without one
+// fall back to the SSH-based drain.
+func drainNodeViaAPI(clientset kubernetes.Interface, nodeName string) error {
+	patch := []byte(`{"spec":{"unschedulable":true}}`)
+	if _, err := clientset.CoreV1().Nodes().Patch(nodeName, types.StrategicMergePatchType, patch); err != nil {
+		return fmt.Errorf("failed to cordon node %s: %v", nodeName, err)
+	}
+
+	pods, err := clientset.CoreV1().Pods(corev1.NamespaceAll).List(metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list pods on node %s: %v", nodeName, err)
+	}
+
+	var evictErrs []error
+	for _, pod := range pods.Items {
+		if isDaemonSetPod(&pod) {
+			continue
+		}
+		eviction := &policyv1beta1.Eviction{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      pod.Name,
+				Namespace: pod.Namespace,
+			},
+		}
+		if err := clientset.PolicyV1beta1().Evictions(pod.Namespace).Evict(eviction); err != nil {
+			evictErrs = append(evictErrs, fmt.Errorf("pod %s/%s: %v", pod.Namespace, pod.Name, err))
+		}
+	}
+	if len(evictErrs) > 0 {
+		return fmt.Errorf("failed to evict %d pod(s) from node %s: %v", len(evictErrs), nodeName, evictErrs)
+	}
+	return nil
+}
+
+// isDaemonSetPod reports whether pod is owned by a DaemonSet, which
+// "kubectl drain --ignore-daemonsets" and this eviction-based drain both
+// leave alone since they are recreated on every node regardless.
+func isDaemonSetPod(pod *corev1.Pod) bool {
+	for _, ref := range pod.OwnerReferences {
+		if ref.Kind == "DaemonSet" {
+			return true
+		}
+	}
+	return false
+}
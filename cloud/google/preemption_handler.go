@@ -0,0 +1,168 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+
+	"github.com/golang/glog"
+	compute "google.golang.org/api/compute/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
+
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// StartPreemptionHandler launches a loop, modeled on Karpenter's node
+// lifecycle management, that watches for preempted GCE instances and
+// reacts the way the normal Delete path would have: drain the node, then
+// either recreate the instance in place (if its Machine asked to preserve
+// its boot disk) or delete the Machine so upstream controllers recreate
+// it, and record an Event so the preemption is visible to operators.
+// clientset is optional; when nil, draining falls back to SSH. It is a
+// no-op if PreemptionPollPeriod was left at zero.
+func (gce *GCEClient) StartPreemptionHandler(cluster *clusterv1.Cluster, clientset kubernetes.Interface, eventRecorder record.EventSink, stopCh <-chan struct{}) {
+	if gce.preemptionPollPeriod <= 0 {
+		return
+	}
+	go wait.Until(func() { gce.reconcilePreemptedInstances(cluster, clientset, eventRecorder) }, gce.preemptionPollPeriod, stopCh)
+}
+
+// reconcilePreemptedInstances lists this cluster's worker instances and
+// handles any that GCE has stopped or terminated due to preemption.
+func (gce *GCEClient) reconcilePreemptedInstances(cluster *clusterv1.Cluster, clientset kubernetes.Interface, eventRecorder record.EventSink) {
+	clusterConfig, err := gce.clusterproviderconfig(cluster.Spec.ProviderConfig)
+	if err != nil {
+		glog.Errorf("preemption handler: cannot unmarshal cluster providerConfig: %v", err)
+		return
+	}
+
+	workerTag := fmt.Sprintf("%s-worker", cluster.Name)
+	instances, err := gce.computeService.InstancesList(clusterConfig.Project, "", workerTag)
+	if err != nil {
+		glog.Errorf("preemption handler: failed to list instances for cluster %s: %v", cluster.Name, err)
+		return
+	}
+
+	machines, err := gce.machineClient.List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("preemption handler: failed to list machines for cluster %s: %v", cluster.Name, err)
+		return
+	}
+
+	for _, instance := range instances {
+		if !wasPreempted(instance) {
+			continue
+		}
+		for i := range machines.Items {
+			machine := &machines.Items[i]
+			if machine.ObjectMeta.Name != instance.Name {
+				continue
+			}
+			gce.handlePreemptedMachine(cluster, machine, clientset, eventRecorder)
+		}
+	}
+}
+
+// wasPreempted reports whether instance appears to have been reclaimed by
+// GCE rather than deleted by us: either it is already TERMINATED, or it
+// carries a LastStopTimestamp without ever being asked to stop through this
+// actuator.
+func wasPreempted(instance *compute.Instance) bool {
+	if instance.Status == "TERMINATED" {
+		return true
+	}
+	return instance.LastStopTimestamp != ""
+}
+
+// handlePreemptedMachine drains the preempted node, then either recreates
+// the instance in place (preserving its name and disks) or deletes the
+// Machine object so the owning controller recreates it, and emits an Event
+// recording why.
+func (gce *GCEClient) handlePreemptedMachine(cluster *clusterv1.Cluster, machine *clusterv1.Machine, clientset kubernetes.Interface, eventRecorder record.EventSink) {
+	glog.Infof("preemption handler: instance for machine %s was preempted, draining", machine.ObjectMeta.Name)
+
+	if clientset != nil {
+		if err := drainNodeViaAPI(clientset, machine.ObjectMeta.Name); err != nil {
+			glog.Errorf("preemption handler: failed to drain preempted node %s via API: %v", machine.ObjectMeta.Name, err)
+		}
+	} else {
+		cmd := fmt.Sprintf("sudo kubectl drain %s --kubeconfig /etc/kubernetes/admin.conf --ignore-daemonsets --delete-local-data", machine.ObjectMeta.Name)
+		if _, err := gce.remoteSshCommand(cluster, machine, cmd); err != nil {
+			glog.Errorf("preemption handler: failed to drain preempted node %s over SSH: %v", machine.ObjectMeta.Name, err)
+		}
+	}
+
+	machineConfig, err := gce.machineproviderconfig(machine.Spec.ProviderConfig)
+	if err != nil {
+		glog.Errorf("preemption handler: cannot unmarshal providerConfig for machine %s: %v", machine.ObjectMeta.Name, err)
+		return
+	}
+
+	reason := "machine deleted for recreation"
+	if machineConfig.PreserveBootDiskOnRecreate {
+		clusterConfig, err := gce.clusterproviderconfig(cluster.Spec.ProviderConfig)
+		if err != nil {
+			glog.Errorf("preemption handler: cannot unmarshal cluster providerConfig: %v", err)
+			return
+		}
+		zone := machineConfig.Zone
+		if annotated := machine.ObjectMeta.Annotations[ZoneAnnotationKey]; annotated != "" {
+			zone = annotated
+		}
+
+		// The preempted instance is still present (just TERMINATED);
+		// instanceIfExists would find it and Create would think there is
+		// nothing to do. Delete it first -- its boot disk survives because
+		// PreserveBootDiskOnRecreate implies AutoDelete=false -- then
+		// create the replacement, which GCE will attach the preserved disk
+		// to under the same name.
+		if err := gce.deleteInstance(clusterConfig, zone, machine.ObjectMeta.Name); err != nil {
+			glog.Errorf("preemption handler: failed to delete terminated instance %s before recreate: %v", machine.ObjectMeta.Name, err)
+			return
+		}
+		if err := gce.Create(cluster, machine); err != nil {
+			glog.Errorf("preemption handler: failed to recreate preempted instance %s in place: %v", machine.ObjectMeta.Name, err)
+			return
+		}
+		reason = "instance recreated in place from its preserved boot disk"
+	} else if err := gce.Delete(cluster, machine); err != nil {
+		glog.Errorf("preemption handler: failed to delete Machine %s after preemption: %v", machine.ObjectMeta.Name, err)
+		return
+	}
+
+	if eventRecorder != nil {
+		event := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: machine.ObjectMeta.Name + "-preempted-",
+				Namespace:    machine.ObjectMeta.Namespace,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				Kind:      "Machine",
+				Name:      machine.ObjectMeta.Name,
+				Namespace: machine.ObjectMeta.Namespace,
+			},
+			Reason:  "InstancePreempted",
+			Message: fmt.Sprintf("GCE instance backing machine %s was preempted; %s", machine.ObjectMeta.Name, reason),
+			Type:    corev1.EventTypeWarning,
+		}
+		if _, err := eventRecorder.Create(event); err != nil {
+			glog.Errorf("preemption handler: failed to record preemption event for %s: %v", machine.ObjectMeta.Name, err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/golang/glog"
+	compute "google.golang.org/api/compute/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	apierrors "sigs.k8s.io/cluster-api/errors"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// orphanVMGracePeriod is how long an instance with no corresponding Machine
+// must be observed before the safety controller deletes it. This absorbs
+// the window between InstancesInsert succeeding and updateAnnotations
+// persisting the Machine's annotations.
+const orphanVMGracePeriod = 5 * time.Minute
+
+// StartSafetyController launches the orphan-VM and overshoot reconciliation
+// loops in the background, mirroring the safety loops in
+// machine-controller-manager. It is a no-op for any loop whose period is
+// zero. dryRun logs what would have been deleted instead of calling
+// InstancesDelete, for operators who want to observe the loop before
+// trusting it.
+func (gce *GCEClient) StartSafetyController(cluster *clusterv1.Cluster, stopCh <-chan struct{}, dryRun bool) {
+	if gce.safetyOrphanVMPeriod > 0 {
+		go wait.Until(func() { gce.reconcileOrphanVMs(cluster, dryRun) }, gce.safetyOrphanVMPeriod, stopCh)
+	}
+	if gce.safetyOvershootPeriod > 0 {
+		go wait.Until(func() { gce.reconcileOvershoot(cluster) }, gce.safetyOvershootPeriod, stopCh)
+	}
+}
+
+// reconcileOrphanVMs lists every GCE instance tagged as belonging to this
+// cluster's workers, cross-references it against the live Machine objects,
+// and deletes any instance whose Machine is gone. It also diffs each
+// instance that does have a Machine against machineproviderconfig and
+// surfaces drift instead of silently ignoring it.
+func (gce *GCEClient) reconcileOrphanVMs(cluster *clusterv1.Cluster, dryRun bool) {
+	clusterConfig, err := gce.clusterproviderconfig(cluster.Spec.ProviderConfig)
+	if err != nil {
+		glog.Errorf("safety controller: cannot unmarshal cluster providerConfig: %v", err)
+		return
+	}
+
+	workerTag := fmt.Sprintf("%s-worker", cluster.Name)
+	instances, err := gce.computeService.InstancesList(clusterConfig.Project, "", workerTag)
+	if err != nil {
+		glog.Errorf("safety controller: failed to list instances for cluster %s: %v", cluster.Name, err)
+		return
+	}
+
+	machines, err := gce.machineClient.List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("safety controller: failed to list machines for cluster %s: %v", cluster.Name, err)
+		return
+	}
+	known := make(map[string]*clusterv1.Machine, len(machines.Items))
+	for i := range machines.Items {
+		known[machines.Items[i].ObjectMeta.Name] = &machines.Items[i]
+	}
+
+	for _, instance := range instances {
+		machine, ok := known[instance.Name]
+		if !ok {
+			if !instanceOlderThan(instance, orphanVMGracePeriod) {
+				glog.V(2).Infof("safety controller: instance %s has no Machine yet, but is within the grace period", instance.Name)
+				continue
+			}
+			if dryRun {
+				glog.Infof("safety controller: dry-run, would delete orphan instance %s", instance.Name)
+				continue
+			}
+			zone := zoneOf(instance)
+			if zone == "" {
+				glog.Errorf("safety controller: instance %s has no known zone, refusing to delete it", instance.Name)
+				continue
+			}
+			glog.Infof("safety controller: deleting orphan instance %s with no corresponding Machine", instance.Name)
+			if _, err := gce.computeService.InstancesDelete(clusterConfig.Project, zone, instance.Name); err != nil {
+				glog.Errorf("safety controller: failed to delete orphan instance %s: %v", instance.Name, err)
+			}
+			continue
+		}
+
+		gce.detectDrift(machine, instance)
+	}
+}
+
+// detectDrift compares the live instance against what the Machine's
+// providerConfig asked for and reports a MachineDrift error rather than
+// leaving the discrepancy unnoticed.
+func (gce *GCEClient) detectDrift(machine *clusterv1.Machine, instance *compute.Instance) {
+	machineConfig, err := gce.machineproviderconfig(machine.Spec.ProviderConfig)
+	if err != nil {
+		glog.Errorf("safety controller: cannot unmarshal providerConfig for machine %s: %v", machine.ObjectMeta.Name, err)
+		return
+	}
+
+	wantType := fmt.Sprintf("zones/%s/machineTypes/%s", machineConfig.Zone, machineConfig.MachineType)
+	if instance.MachineType != "" && instance.MachineType != wantType {
+		gce.handleMachineError(machine, apierrors.MachineDrift(
+			"instance %s has machine type %q, Machine wants %q", instance.Name, instance.MachineType, wantType))
+		return
+	}
+	if len(instance.Disks) != len(machineConfig.Disks) {
+		gce.handleMachineError(machine, apierrors.MachineDrift(
+			"instance %s has %d disks, Machine wants %d", instance.Name, len(instance.Disks), len(machineConfig.Disks)))
+		return
+	}
+}
+
+// reconcileOvershoot detects clusters with more live instances than their
+// Machine objects request and requeues so the normal Delete path can catch
+// up, rather than leaving the excess capacity running indefinitely.
+func (gce *GCEClient) reconcileOvershoot(cluster *clusterv1.Cluster) {
+	clusterConfig, err := gce.clusterproviderconfig(cluster.Spec.ProviderConfig)
+	if err != nil {
+		glog.Errorf("safety controller: cannot unmarshal cluster providerConfig: %v", err)
+		return
+	}
+
+	workerTag := fmt.Sprintf("%s-worker", cluster.Name)
+	instances, err := gce.computeService.InstancesList(clusterConfig.Project, "", workerTag)
+	if err != nil {
+		glog.Errorf("safety controller: failed to list instances for cluster %s: %v", cluster.Name, err)
+		return
+	}
+	machines, err := gce.machineClient.List(metav1.ListOptions{})
+	if err != nil {
+		glog.Errorf("safety controller: failed to list machines for cluster %s: %v", cluster.Name, err)
+		return
+	}
+
+	if overshoot := len(instances) - len(machines.Items); overshoot > 0 {
+		glog.Errorf("safety controller: cluster %s has %d more instances than Machine objects, requeuing for reconciliation", cluster.Name, overshoot)
+	}
+}
+
+// instanceOlderThan reports whether instance's creation timestamp is at
+// least age in the past. Instances without a parseable timestamp -- e.g.
+// the grpc compute service transport, whose ListMachines RPC has no
+// timestamp field to report -- are treated as NOT old, so a backend that
+// can't tell us an instance's age never causes it to bypass the grace
+// period and get deleted out from under a reconcile that just created it.
+func instanceOlderThan(instance *compute.Instance, age time.Duration) bool {
+	created, err := time.Parse(time.RFC3339, instance.CreationTimestamp)
+	if err != nil {
+		return false
+	}
+	return time.Since(created) >= age
+}
+
+// zoneOf extracts the zone name from an instance's self-link-style Zone
+// field (".../zones/<zone>"), falling back to the raw value if it isn't a
+// full URL. Returns "" if instance has no Zone at all -- e.g. the grpc
+// compute service transport, whose ListMachines RPC doesn't report one --
+// rather than path.Base("")'s ".", which would look like a real zone to a
+// caller that didn't check for it.
+func zoneOf(instance *compute.Instance) string {
+	if instance.Zone == "" {
+		return ""
+	}
+	return path.Base(instance.Zone)
+}
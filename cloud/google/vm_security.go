@@ -0,0 +1,114 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+
+	gceconfigv1 "sigs.k8s.io/cluster-api/cloud/google/gceproviderconfig/v1alpha1"
+	apierrors "sigs.k8s.io/cluster-api/errors"
+)
+
+// confidentialComputeMachineFamilies lists the machine-type prefixes GCE
+// supports for Confidential VM today (AMD SEV requires these families).
+var confidentialComputeMachineFamilies = []string{"n2d-", "c2d-"}
+
+// validateInstanceSecurityConfig is an admission-style check run before
+// computeService.InstancesInsert, catching mutually-exclusive or
+// unsupported combinations of Shielded VM, Confidential VM, and CMEK
+// settings up front instead of letting the GCE API reject them.
+func validateInstanceSecurityConfig(config *gceconfigv1.GCEMachineProviderConfig) *apierrors.MachineError {
+	confidential := config.ConfidentialInstanceConfig
+	shielded := config.ShieldedInstanceConfig
+
+	if !confidential.EnableConfidentialCompute {
+		return nil
+	}
+
+	if !hasConfidentialComputeMachineFamily(config.MachineType) {
+		return apierrors.InvalidMachineConfiguration(
+			"confidential compute requires a machine type from %v, got %q", confidentialComputeMachineFamilies, config.MachineType)
+	}
+
+	// Confidential VM disables live migration; it must be paired with
+	// OnHostMaintenance=TERMINATE or GCE will reject the insert.
+	if config.Scheduling.OnHostMaintenance != "" && config.Scheduling.OnHostMaintenance != "TERMINATE" {
+		return apierrors.InvalidMachineConfiguration(
+			"confidential compute requires scheduling.onHostMaintenance=TERMINATE, got %q", config.Scheduling.OnHostMaintenance)
+	}
+
+	// Confidential VM requires the boot disk be backed by a vTPM, so
+	// ShieldedInstanceConfig can't disable it while confidential compute is on.
+	if shielded.EnableVtpm != nil && !*shielded.EnableVtpm {
+		return apierrors.InvalidMachineConfiguration(
+			"confidential compute requires shieldedInstanceConfig.enableVtpm, it cannot be disabled")
+	}
+
+	return nil
+}
+
+func hasConfidentialComputeMachineFamily(machineType string) bool {
+	for _, family := range confidentialComputeMachineFamilies {
+		if strings.HasPrefix(machineType, family) {
+			return true
+		}
+	}
+	return false
+}
+
+// shieldedInstanceConfigFor translates GCEMachineProviderConfig's
+// ShieldedInstanceConfig into the compute API type, or nil if the user
+// didn't ask for any of it.
+func shieldedInstanceConfigFor(config *gceconfigv1.GCEMachineProviderConfig) *compute.ShieldedInstanceConfig {
+	s := config.ShieldedInstanceConfig
+	if s.EnableSecureBoot == nil && s.EnableVtpm == nil && s.EnableIntegrityMonitoring == nil {
+		return nil
+	}
+	shielded := &compute.ShieldedInstanceConfig{}
+	if s.EnableSecureBoot != nil {
+		shielded.EnableSecureBoot = *s.EnableSecureBoot
+	}
+	if s.EnableVtpm != nil {
+		shielded.EnableVtpm = *s.EnableVtpm
+	}
+	if s.EnableIntegrityMonitoring != nil {
+		shielded.EnableIntegrityMonitoring = *s.EnableIntegrityMonitoring
+	}
+	return shielded
+}
+
+// confidentialInstanceConfigFor translates
+// GCEMachineProviderConfig.ConfidentialInstanceConfig into the compute API
+// type, or nil if confidential compute wasn't requested.
+func confidentialInstanceConfigFor(config *gceconfigv1.GCEMachineProviderConfig) *compute.ConfidentialInstanceConfig {
+	if !config.ConfidentialInstanceConfig.EnableConfidentialCompute {
+		return nil
+	}
+	return &compute.ConfidentialInstanceConfig{
+		EnableConfidentialCompute: true,
+	}
+}
+
+// diskEncryptionKeyFor builds the CMEK disk-encryption-key setting for a
+// single disk, or nil if the disk didn't request one.
+func diskEncryptionKeyFor(kmsKeyName string) *compute.CustomerEncryptionKey {
+	if kmsKeyName == "" {
+		return nil
+	}
+	return &compute.CustomerEncryptionKey{
+		KmsKeyName: kmsKeyName,
+	}
+}
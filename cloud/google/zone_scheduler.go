@@ -0,0 +1,179 @@
+/*
+Copyright 2018 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package google
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"sigs.k8s.io/cluster-api/util"
+
+	gceconfigv1 "sigs.k8s.io/cluster-api/cloud/google/gceproviderconfig/v1alpha1"
+	clusterv1 "sigs.k8s.io/cluster-api/pkg/apis/cluster/v1alpha1"
+)
+
+// ZoneSelectionPolicy controls how GCEClient picks a zone for a machine
+// whose providerConfig sets Region instead of a single Zone.
+type ZoneSelectionPolicy string
+
+const (
+	// RoundRobinPolicy cycles through the region's zones in order.
+	RoundRobinPolicy ZoneSelectionPolicy = "RoundRobin"
+	// LeastLoadedPolicy picks whichever zone currently hosts the fewest of
+	// this cluster's instances.
+	LeastLoadedPolicy ZoneSelectionPolicy = "LeastLoaded"
+	// ExplicitPolicy keeps the existing behavior of using
+	// GCEMachineProviderConfig.Zone directly.
+	ExplicitPolicy ZoneSelectionPolicy = "Explicit"
+
+	// minEtcdQuorumZones is the minimum number of zones control-plane
+	// machines must be spread across for etcd to tolerate a zone outage.
+	minEtcdQuorumZones = 3
+)
+
+// zoneRoundRobin tracks the next zone index per cluster+region so repeated
+// RoundRobinPolicy selections actually spread out instead of always
+// landing on the first zone.
+type zoneRoundRobin struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (z *zoneRoundRobin) nextIndex(key string, count int) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.next == nil {
+		z.next = make(map[string]int)
+	}
+	idx := z.next[key] % count
+	z.next[key] = z.next[key] + 1
+	return idx
+}
+
+// selectZone picks the zone to create machine in. If the providerConfig
+// already named an explicit Zone (the common case today), or the Machine
+// was already assigned a zone on a previous reconcile (recorded under
+// ZoneAnnotationKey, the same annotation updateAnnotations persists after
+// every successful Create), that zone is reused verbatim so the choice is
+// idempotent. Otherwise a zone is selected from Region according to
+// ZoneSelectionPolicy.
+func (gce *GCEClient) selectZone(cluster *clusterv1.Cluster, machine *clusterv1.Machine, clusterConfig *gceconfigv1.GCEClusterProviderConfig, machineConfig *gceconfigv1.GCEMachineProviderConfig) (string, error) {
+	if zone, ok := machine.ObjectMeta.Annotations[ZoneAnnotationKey]; ok && zone != "" {
+		return zone, nil
+	}
+	if machineConfig.Zone != "" || machineConfig.Region == "" {
+		return machineConfig.Zone, nil
+	}
+
+	zones, err := gce.zonesWithCapacity(clusterConfig.Project, machineConfig.Region, machineConfig.MachineType)
+	if err != nil {
+		return "", err
+	}
+	if util.IsMaster(machine) && len(zones) < minEtcdQuorumZones {
+		return "", fmt.Errorf("control-plane machine %s needs at least %d zones with capacity in region %s for etcd quorum, found %d",
+			machine.ObjectMeta.Name, minEtcdQuorumZones, machineConfig.Region, len(zones))
+	}
+
+	switch machineConfig.ZoneSelectionPolicy {
+	case LeastLoadedPolicy:
+		return gce.leastLoadedZone(clusterConfig.Project, cluster.Name, zones)
+	case RoundRobinPolicy, "":
+		idx := gce.zoneRoundRobin.nextIndex(clusterConfig.Project+"|"+machineConfig.Region, len(zones))
+		return zones[idx], nil
+	default:
+		return "", fmt.Errorf("unknown ZoneSelectionPolicy %q", machineConfig.ZoneSelectionPolicy)
+	}
+}
+
+// zonesWithCapacity lists the zones in region that can host machineType,
+// by asking the compute API for every zone and filtering to ones whose
+// name falls within the region -- capacity itself is enforced by GCE at
+// insert time; this just avoids obviously out-of-region placements.
+func (gce *GCEClient) zonesWithCapacity(project string, region string, machineType string) ([]string, error) {
+	zones, err := gce.computeService.ZonesList(project, region)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list zones for region %s: %v", region, err)
+	}
+	var names []string
+	for _, zone := range zones {
+		if strings.HasPrefix(zone.Name, region+"-") {
+			names = append(names, zone.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no zones with capacity for machine type %s found in region %s", machineType, region)
+	}
+	return names, nil
+}
+
+// leastLoadedZone returns whichever of zones currently hosts the fewest of
+// this cluster's worker instances.
+func (gce *GCEClient) leastLoadedZone(project string, clusterName string, zones []string) (string, error) {
+	workerTag := fmt.Sprintf("%s-worker", clusterName)
+	counts := make(map[string]int, len(zones))
+	for _, zone := range zones {
+		instances, err := gce.computeService.InstancesList(project, zone, workerTag)
+		if err != nil {
+			return "", fmt.Errorf("failed to list instances in zone %s: %v", zone, err)
+		}
+		counts[zone] = len(instances)
+	}
+
+	best := zones[0]
+	for _, zone := range zones[1:] {
+		if counts[zone] < counts[best] {
+			best = zone
+		}
+	}
+	return best, nil
+}
+
+// GetAllCurrentZones returns only the zones that currently host Ready
+// nodes in cluster, so callers placing zone-pinned resources (e.g.
+// regional PD-backed volumes) don't land in a zone with nothing able to
+// schedule against it.
+func (gce *GCEClient) GetAllCurrentZones(cluster *clusterv1.Cluster, master *clusterv1.Machine) ([]string, error) {
+	kubeconfig, err := gce.GetKubeConfig(cluster, master)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch kubeconfig to list zones: %v", err)
+	}
+
+	tmp, err := ioutil.TempFile("", "zone-list-kubeconfig")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temp kubeconfig: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(kubeconfig); err != nil {
+		return nil, fmt.Errorf("could not write temp kubeconfig: %v", err)
+	}
+	tmp.Close()
+
+	out := util.ExecCommand("kubectl", "--kubeconfig", tmp.Name(), "get", "nodes",
+		"-o", `jsonpath={range .items[?(@.status.conditions[-1].type=="Ready")]}{.metadata.labels.topology\.kubernetes\.io/zone}{"\n"}{end}`)
+
+	seen := make(map[string]bool)
+	var zones []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		zone := strings.TrimSpace(line)
+		if zone == "" || seen[zone] {
+			continue
+		}
+		seen[zone] = true
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}